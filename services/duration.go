@@ -0,0 +1,38 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration wraps time.Duration to allow config fields to be expressed as
+// human-readable strings (e.g. "5s", "1m30s") as well as plain integer
+// nanoseconds.
+type Duration time.Duration
+
+// MarshalJSON renders the duration as its string form.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts either a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var asString string
+	if err := json.Unmarshal(data, &asString); err == nil {
+		parsed, err := time.ParseDuration(asString)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var asNanos int64
+	if err := json.Unmarshal(data, &asNanos); err != nil {
+		return errors.WithStack(err)
+	}
+	*d = Duration(asNanos)
+	return nil
+}
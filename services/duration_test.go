@@ -0,0 +1,50 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshalString(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"5s"`), &d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Duration(d) != 5*time.Second {
+		t.Errorf("got %v, want 5s", time.Duration(d))
+	}
+}
+
+func TestDurationUnmarshalNanos(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`1500000000`), &d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Duration(d) != 1500*time.Millisecond {
+		t.Errorf("got %v, want 1.5s", time.Duration(d))
+	}
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	original := Duration(30 * time.Second)
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded Duration
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded != original {
+		t.Errorf("got %v, want %v", decoded, original)
+	}
+}
+
+func TestDurationUnmarshalInvalid(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"not-a-duration"`), &d); err == nil {
+		t.Error("expected an error for an invalid duration string")
+	}
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+	"github.com/yext/edward/home"
+)
+
+// HealthState describes the outcome of a service's periodic health checks,
+// modeled after container healthcheck subsystems.
+type HealthState string
+
+const (
+	// HealthStarting means the service is within its StartPeriod and no
+	// verdict has been reached yet.
+	HealthStarting HealthState = "Starting"
+	// HealthHealthy means the most recent probe(s) succeeded.
+	HealthHealthy HealthState = "Healthy"
+	// HealthUnhealthy means the probe has failed Retries times in a row.
+	HealthUnhealthy HealthState = "Unhealthy"
+)
+
+// HealthCheck defines a probe that keeps running for the life of a service,
+// unlike LaunchChecks which only gate startup. Exactly one of HTTPGet, TCP or
+// Exec should be set.
+type HealthCheck struct {
+	HTTPGet *HTTPGetProbe `json:"http_get,omitempty"`
+	TCP     *TCPProbe     `json:"tcp,omitempty"`
+	Exec    *ExecProbe    `json:"exec,omitempty"`
+
+	// Interval is the time between probes.
+	Interval Duration `json:"interval,omitempty"`
+	// Timeout is how long a single probe may take before it counts as a
+	// failure.
+	Timeout Duration `json:"timeout,omitempty"`
+	// Retries is the number of consecutive failures before the service is
+	// marked HealthUnhealthy.
+	Retries int `json:"retries,omitempty"`
+	// StartPeriod is an initial grace period during which failures don't
+	// count against Retries.
+	StartPeriod Duration `json:"start_period,omitempty"`
+	// UnhealthyRestart, if set and AutoRestart is enabled, restarts the
+	// service once it is HealthUnhealthy and has failed at least this many
+	// consecutive probes.
+	UnhealthyRestart int `json:"unhealthy_restart,omitempty"`
+}
+
+// HTTPGetProbe considers a service healthy if a GET to URL returns
+// ExpectStatus (200 if unset).
+type HTTPGetProbe struct {
+	URL          string            `json:"url"`
+	ExpectStatus int               `json:"expect_status,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// TCPProbe considers a service healthy if a connection to Port succeeds.
+type TCPProbe struct {
+	Port int `json:"port"`
+}
+
+// ExecProbe considers a service healthy if Command exits zero.
+type ExecProbe struct {
+	Command string `json:"command"`
+}
+
+// healthRecord is the persisted state written by RunInternalHealthCheck and
+// read by Health.
+type healthRecord struct {
+	State     HealthState `json:"state"`
+	CheckedAt time.Time   `json:"checkedAt"`
+}
+
+// internalHealthCheckFlag is the hidden argument edward's main() must check
+// for, alongside internalSuperviseFlag: if os.Args[1] equals this, the
+// process is a detached health prober started by StartHealthCheck, and
+// main() should call RunInternalHealthCheck(os.Args[2]) and exit.
+//
+// Like the supervisor (see supervisor.go:27-37), the prober has to run as
+// its own long-lived process rather than a goroutine in the `edward start`
+// process: that process exits once the service is launched, and a later
+// `edward status` reads Health() on a freshly unmarshaled ServiceConfig that
+// never ran any goroutine of its own.
+const internalHealthCheckFlag = "--edward-internal-healthcheck"
+
+// healthCheckSpec is everything a detached health prober needs, since it has
+// no access to the edward.json or in-memory ServiceConfig that started it.
+type healthCheckSpec struct {
+	Name        string       `json:"name"`
+	Check       *HealthCheck `json:"check"`
+	PidFile     string       `json:"pidFile"`
+	AutoRestart bool         `json:"autoRestart"`
+}
+
+// GetHealthStatePath returns the path to the persisted healthRecord for this
+// service.
+func (c *ServiceConfig) GetHealthStatePath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".health")
+}
+
+// GetHealthSpecPath returns the path to the persisted healthCheckSpec that a
+// detached health prober reads on startup.
+func (c *ServiceConfig) GetHealthSpecPath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".health.json")
+}
+
+// GetHealthPidPath returns the path to the pid file for this service's
+// detached health prober, if one is running.
+func (c *ServiceConfig) GetHealthPidPath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".health.pid")
+}
+
+// Health returns the most recently observed health state for this service,
+// and when it was last checked. If no health check is configured, or none
+// has run yet, it returns ("", time.Time{}).
+func (c *ServiceConfig) Health() (HealthState, time.Time) {
+	record, err := c.loadHealthRecord()
+	if err != nil {
+		return "", time.Time{}
+	}
+	return record.State, record.CheckedAt
+}
+
+func (c *ServiceConfig) loadHealthRecord() (healthRecord, error) {
+	var record healthRecord
+	data, err := ioutil.ReadFile(c.GetHealthStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return record, nil
+		}
+		return record, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, errors.WithStack(err)
+	}
+	return record, nil
+}
+
+func (c *ServiceConfig) saveHealthRecord(state HealthState) error {
+	data, err := json.Marshal(healthRecord{State: state, CheckedAt: time.Now()})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(c.GetHealthStatePath(), data, 0644))
+}
+
+func (c *ServiceConfig) clearHealthState() {
+	os.Remove(c.GetHealthStatePath())
+	os.Remove(c.GetHealthSpecPath())
+}
+
+// StartHealthCheck launches a detached process that probes this service on
+// HealthCheck.Interval, following the same re-exec + Setsid approach as
+// StartSupervisor, so probing (and the health state Status reports) survives
+// past the `edward start` invocation that called this. The pid argument is
+// not used: the prober reads the service's current pid from its pid file
+// when it needs to signal it.
+func (c *ServiceConfig) StartHealthCheck(pid int) error {
+	spec := healthCheckSpec{
+		Name:        c.Name,
+		Check:       c.HealthCheck,
+		PidFile:     c.supervisedPidFile(),
+		AutoRestart: c.AutoRestart,
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(c.GetHealthSpecPath(), data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer devnull.Close()
+
+	cmd := exec.Command(self, internalHealthCheckFlag, c.GetHealthSpecPath())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Stdin = devnull
+	cmd.Stdout = devnull
+	cmd.Stderr = devnull
+	if err := cmd.Start(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(c.GetHealthPidPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0644))
+}
+
+// StopHealthCheck signals a detached prober started by StartHealthCheck to
+// exit. Safe to call even if no prober is running.
+func (c *ServiceConfig) StopHealthCheck() error {
+	data, err := ioutil.ReadFile(c.GetHealthPidPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if p, err := process.NewProcess(int32(pid)); err == nil {
+		p.SendSignal(syscall.SIGTERM)
+	}
+	os.Remove(c.GetHealthPidPath())
+	return nil
+}
+
+// RunInternalHealthCheck is the entry point for a detached prober started by
+// StartHealthCheck. It probes on Check.Interval for as long as it isn't
+// cancelled by SIGTERM/SIGINT (sent by StopHealthCheck), flipping the
+// persisted HealthState between Starting/Healthy/Unhealthy and logging each
+// probe as a "health" event in the service's run log. If UnhealthyRestart is
+// set and the service has AutoRestart enabled, it signals the currently
+// running process (re-read from PidFile on every failure, since the
+// supervisor may have relaunched it) to exit once the service is
+// HealthUnhealthy and has failed at least that many consecutive probes,
+// letting the supervisor relaunch it.
+func RunInternalHealthCheck(specPath string) error {
+	data, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var spec healthCheckSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c := &ServiceConfig{Name: spec.Name}
+	check := spec.Check
+
+	cancel := installSignalCancel()
+
+	if err := c.saveHealthRecord(HealthStarting); err != nil {
+		return errors.WithStack(err)
+	}
+
+	startPeriod := time.Duration(check.StartPeriod)
+	select {
+	case <-time.After(startPeriod):
+	case <-cancel:
+		return nil
+	}
+
+	interval := time.Duration(check.Interval)
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	var consecutiveFailures int
+	for {
+		err := c.probe(check)
+		if err == nil {
+			consecutiveFailures = 0
+			c.saveHealthRecord(HealthHealthy)
+			c.logHealthEvent(true, "")
+		} else {
+			consecutiveFailures++
+			c.logHealthEvent(false, err.Error())
+			if consecutiveFailures >= maxInt(check.Retries, 1) {
+				c.saveHealthRecord(HealthUnhealthy)
+				if check.UnhealthyRestart > 0 && spec.AutoRestart && consecutiveFailures >= check.UnhealthyRestart {
+					if pid, err := readPidFile(spec.PidFile); err == nil {
+						if p, perr := process.NewProcess(int32(pid)); perr == nil {
+							p.SendSignal(syscall.SIGTERM)
+						}
+					}
+					consecutiveFailures = 0
+				}
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-cancel:
+			return nil
+		}
+	}
+}
+
+// readPidFile reads the numeric pid persisted at path, as used by both the
+// ordinary pid file (ServiceCommand) and the supervisor's PidFile.
+func readPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return pid, nil
+}
+
+func (c *ServiceConfig) probe(check *HealthCheck) error {
+	timeout := time.Duration(check.Timeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	switch {
+	case check.HTTPGet != nil:
+		return probeHTTPGet(check.HTTPGet, timeout)
+	case check.TCP != nil:
+		return probeTCP(check.TCP, timeout)
+	case check.Exec != nil:
+		return probeExec(check.Exec, timeout)
+	}
+	return errors.New("health check has no probe configured")
+}
+
+func probeHTTPGet(p *HTTPGetProbe, timeout time.Duration) error {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for key, value := range p.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	expect := p.ExpectStatus
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+	if resp.StatusCode != expect {
+		return errors.Errorf("expected status %d, got %d", expect, resp.StatusCode)
+	}
+	return nil
+}
+
+func probeTCP(p *TCPProbe, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", p.Port), timeout)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return conn.Close()
+}
+
+func probeExec(p *ExecProbe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.Command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, stderr.String())
+	}
+	return nil
+}
+
+func (c *ServiceConfig) logHealthEvent(healthy bool, message string) {
+	logFile, err := os.OpenFile(c.GetRunLog(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer logFile.Close()
+
+	event := struct {
+		Stream  string `json:"stream"`
+		Time    int64  `json:"time"`
+		Healthy bool   `json:"healthy"`
+		Message string `json:"message,omitempty"`
+	}{
+		Stream:  "health",
+		Time:    time.Now().Unix(),
+		Healthy: healthy,
+		Message: message,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	logFile.Write(data)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// defaultLogTextTimeout is used when LaunchChecks.Timeout isn't set.
+const defaultLogTextTimeout = 30 * time.Second
+
+// logTextPollInterval is the fallback polling interval used when the log
+// file can't be watched with fsnotify.
+const logTextPollInterval = 200 * time.Millisecond
+
+// logMatcher returns a predicate for whether a log line satisfies this
+// LaunchChecks' LogText or LogTextRegex, whichever is set.
+func (lc *LaunchChecks) logMatcher() (func(line string) bool, error) {
+	if lc.LogTextRegex != "" {
+		re, err := regexp.Compile(lc.LogTextRegex)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return re.MatchString, nil
+	}
+	text := lc.LogText
+	return func(line string) bool {
+		return strings.Contains(line, text)
+	}, nil
+}
+
+func (lc *LaunchChecks) sentinelDescription() string {
+	if lc.LogTextRegex != "" {
+		return lc.LogTextRegex
+	}
+	return lc.LogText
+}
+
+// LogSize returns the current size of this service's run log, or 0 if it
+// doesn't exist yet. Launch calls this before starting the process so that
+// WaitForLogText can tail from this run's output, rather than from whatever
+// the log's end-of-file happens to be once the tailer goroutine gets
+// scheduled.
+func (c *ServiceConfig) LogSize() int64 {
+	info, err := os.Stat(c.GetRunLog())
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// WaitForLogText tails this service's run log, starting from fromOffset (see
+// LogSize), and returns as soon as a line matches
+// LaunchChecks.LogText/LogTextRegex, rather than the older approach of
+// scanning the log for the sentinel once Launch has already returned.
+// progress, if non-nil, is called after every line scanned so a caller such
+// as CommandTracker can render a live indicator instead of appearing to
+// hang. During StartPeriod, lines reporting that a port is already in use
+// are ignored rather than causing a premature failure.
+func (c *ServiceConfig) WaitForLogText(fromOffset int64, progress func(linesScanned int, elapsed time.Duration)) error {
+	if c.LaunchChecks == nil || (c.LaunchChecks.LogText == "" && c.LaunchChecks.LogTextRegex == "") {
+		return nil
+	}
+
+	match, err := c.LaunchChecks.logMatcher()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	timeout := time.Duration(c.LaunchChecks.Timeout)
+	if timeout <= 0 {
+		timeout = defaultLogTextTimeout
+	}
+	startPeriod := time.Duration(c.LaunchChecks.StartPeriod)
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go tailFile(c.GetRunLog(), fromOffset, lines, done)
+
+	start := time.Now()
+	deadline := time.After(timeout)
+	var scanned int
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return errors.Errorf("run log for %v closed before %q was seen", c.Name, c.LaunchChecks.sentinelDescription())
+			}
+			scanned++
+			if progress != nil {
+				progress(scanned, time.Since(start))
+			}
+			if match(line) {
+				return nil
+			}
+			if strings.Contains(strings.ToLower(line), "address already in use") {
+				if time.Since(start) < startPeriod {
+					continue
+				}
+				return errors.Errorf("%v reported %q before %q was seen", c.Name, line, c.LaunchChecks.sentinelDescription())
+			}
+		case <-deadline:
+			return errors.Errorf("timed out after %v waiting for %q in log for %v", timeout, c.LaunchChecks.sentinelDescription(), c.Name)
+		}
+	}
+}
+
+// tailFile streams lines appended to path at or after fromOffset to out,
+// following truncation and rotation. It prefers fsnotify to wake up on
+// writes, and falls back to polling (in the style of hpcloud/tail) when the
+// watch can't be established, e.g. on filesystems that don't support
+// inotify.
+func tailFile(path string, fromOffset int64, out chan<- string, done <-chan struct{}) {
+	defer close(out)
+
+	watcher, watchErr := fsnotify.NewWatcher()
+	usingWatcher := watchErr == nil
+	if usingWatcher {
+		defer watcher.Close()
+		if err := watcher.Add(path); err != nil {
+			usingWatcher = false
+		}
+	}
+
+	file, offset, err := openAtOffset(path, fromOffset)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	poll := time.NewTicker(logTextPollInterval)
+	defer poll.Stop()
+
+	readAvailable := func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case out <- strings.TrimRight(line, "\n"):
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-poll.C:
+			if info, statErr := os.Stat(path); statErr == nil && info.Size() < offset {
+				// The log was truncated or rotated: reopen from the start.
+				file.Close()
+				file, offset, err = openAtOffset(path, 0)
+				if err != nil {
+					return
+				}
+				reader = bufio.NewReader(file)
+			}
+			readAvailable()
+			if pos, err := file.Seek(0, io.SeekCurrent); err == nil {
+				offset = pos
+			}
+		case event, ok := <-watcherEvents(watcher, usingWatcher):
+			if !ok {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				readAvailable()
+				if pos, err := file.Seek(0, io.SeekCurrent); err == nil {
+					offset = pos
+				}
+			}
+		}
+	}
+}
+
+// watcherEvents returns w.Events, or a nil channel (which blocks forever)
+// when no usable watcher is available, so the poll ticker drives tailFile
+// instead.
+func watcherEvents(w *fsnotify.Watcher, ok bool) chan fsnotify.Event {
+	if !ok {
+		return nil
+	}
+	return w.Events
+}
+
+// openAtOffset opens path and seeks to offset, so a tailer can pick up
+// exactly where a prior read of the file (or LogSize, taken before this
+// run's process started) left off, instead of always attaching at
+// whatever the file's end happens to be once the tailer runs.
+func openAtOffset(path string, offset int64) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, errors.WithStack(err)
+	}
+	return file, offset, nil
+}
+
+func openAtEnd(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, 0, errors.WithStack(err)
+	}
+	return file, offset, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLogMatcherLogText(t *testing.T) {
+	lc := &LaunchChecks{LogText: "started"}
+	match, err := lc.logMatcher()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !match("server started on :8080") {
+		t.Error("expected LogText to match as a literal substring")
+	}
+	if match("server stopped") {
+		t.Error("expected LogText not to match an unrelated line")
+	}
+}
+
+func TestLogMatcherLogTextRegex(t *testing.T) {
+	lc := &LaunchChecks{LogTextRegex: "^ready$"}
+	match, err := lc.logMatcher()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !match("ready") {
+		t.Error("expected LogTextRegex to match the full line")
+	}
+	if match("not ready") {
+		t.Error("expected LogTextRegex not to match a line that fails the anchor")
+	}
+}
+
+func TestLogMatcherInvalidRegex(t *testing.T) {
+	lc := &LaunchChecks{LogTextRegex: "("}
+	if _, err := lc.logMatcher(); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestTailFileStreamsAppendedLines(t *testing.T) {
+	file, err := ioutil.TempFile("", "logcheck-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go tailFile(file.Name(), 0, lines, done)
+
+	if err := ioutil.WriteFile(file.Name(), []byte("line one\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case line := <-lines:
+		if line != "line one" {
+			t.Errorf("got %q, want %q", line, "line one")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for tailFile to report the appended line")
+	}
+}
+
+func TestTailFileDoesNotMissLinesWrittenBeforeItAttaches(t *testing.T) {
+	file, err := ioutil.TempFile("", "logcheck-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+
+	// Simulate a prior run's output already in the log, and this run's
+	// process writing its sentinel before the tailer goroutine attaches -
+	// the race WaitForLogText's fromOffset parameter exists to avoid.
+	if err := ioutil.WriteFile(file.Name(), []byte("old run: started\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fromOffset := int64(len("old run: started\n"))
+	if err := ioutil.WriteFile(file.Name(), []byte("old run: started\nnew run: started\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go tailFile(file.Name(), fromOffset, lines, done)
+
+	select {
+	case line := <-lines:
+		if line != "new run: started" {
+			t.Errorf("got %q, want %q", line, "new run: started")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for tailFile to report the line written before it attached")
+	}
+}
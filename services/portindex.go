@@ -0,0 +1,106 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+)
+
+// portIndexTTL bounds how long a snapshot is reused before Status rebuilds
+// it, so a long-running `edward status` loop doesn't return stale data
+// forever, while repeated calls within a tight loop don't each pay for a
+// fresh full scan.
+const portIndexTTL = 2 * time.Second
+
+// PortIndex is a single-pass snapshot of which pid owns which listening
+// ports, plus a parent->children map, built at most once per portIndexTTL
+// and shared by every ServiceConfig's Status() call, instead of walking the
+// connection table separately for every ServiceConfig.
+type PortIndex struct {
+	byPid    map[int32][]int
+	children map[int32][]int32
+	builtAt  time.Time
+}
+
+// Ports returns every listening port owned by pid or any of its descendant
+// processes, as captured in this snapshot.
+func (idx *PortIndex) Ports(pid int32) []int {
+	var ports []int
+	idx.collect(pid, &ports, make(map[int32]bool))
+	return ports
+}
+
+func (idx *PortIndex) collect(pid int32, out *[]int, seen map[int32]bool) {
+	if seen[pid] {
+		return
+	}
+	seen[pid] = true
+	*out = append(*out, idx.byPid[pid]...)
+	for _, child := range idx.children[pid] {
+		idx.collect(child, out, seen)
+	}
+}
+
+var (
+	portIndexMu     sync.Mutex
+	cachedPortIndex *PortIndex
+)
+
+// getPortIndex returns the current PortIndex snapshot, rebuilding it if none
+// exists yet or the cached one is older than portIndexTTL.
+func getPortIndex() (*PortIndex, error) {
+	portIndexMu.Lock()
+	defer portIndexMu.Unlock()
+
+	if cachedPortIndex != nil && time.Since(cachedPortIndex.builtAt) < portIndexTTL {
+		return cachedPortIndex, nil
+	}
+
+	idx, err := buildPortIndex()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cachedPortIndex = idx
+	return idx, nil
+}
+
+// invalidatePortIndex discards the cached snapshot, forcing the next
+// getPortIndex call to rebuild it. Called from Launch and Stop, since
+// starting or stopping a service is exactly when the port table changes.
+func invalidatePortIndex() {
+	portIndexMu.Lock()
+	cachedPortIndex = nil
+	portIndexMu.Unlock()
+}
+
+// buildPortIndex does one pass over the system's listening sockets
+// (listeningPorts, which has a build-tagged fast path on Linux) and one pass
+// over process.Processes() to build the parent->children map used to
+// attribute a child process's ports back to its service.
+func buildPortIndex() (*PortIndex, error) {
+	byPid, err := listeningPorts()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	children := make(map[int32][]int32, len(procs))
+	for _, p := range procs {
+		ppid, err := p.Ppid()
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], p.Pid)
+	}
+
+	return &PortIndex{
+		byPid:    byPid,
+		children: children,
+		builtAt:  time.Now(),
+	}, nil
+}
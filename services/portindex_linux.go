@@ -0,0 +1,105 @@
+// +build linux
+
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listeningPorts returns every LISTEN-state port, keyed by owning pid, by
+// reading /proc/net/{tcp,tcp6} directly and resolving each socket inode to a
+// pid via /proc/<pid>/fd symlinks. This avoids the netlink/syscall cost
+// gopsutil's net.Connections pays on every call, which dominates `edward
+// status` latency once dozens of services are configured.
+func listeningPorts() (map[int32][]int, error) {
+	inodeToPort, err := scanProcNet([]string{"/proc/net/tcp", "/proc/net/tcp6"})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(inodeToPort) == 0 {
+		return map[int32][]int{}, nil
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	byPid := make(map[int32][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// Process exited between the readdir and now, or we don't have
+			// permission to inspect its fds; either way, skip it.
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if !strings.HasPrefix(link, "socket:[") || !strings.HasSuffix(link, "]") {
+				continue
+			}
+			inode := link[len("socket:[") : len(link)-1]
+			if port, ok := inodeToPort[inode]; ok {
+				byPid[int32(pid)] = append(byPid[int32(pid)], port)
+			}
+		}
+	}
+	return byPid, nil
+}
+
+// scanProcNet parses the listening sockets out of the given /proc/net/*
+// files (normally tcp and tcp6), returning a map from socket inode (as
+// referenced by /proc/<pid>/fd symlinks) to local port. UDP is intentionally
+// not scanned here: UDP sockets have no TCP_LISTEN-equivalent state, so
+// every open UDP socket (including purely outbound ones, e.g. a DNS lookup)
+// would otherwise be reported as an "open port". This matches
+// portindex_other.go, which filters gopsutil connections on
+// Status == "LISTEN" and so effectively excludes UDP too.
+func scanProcNet(paths []string) (map[string]int, error) {
+	inodeToPort := make(map[string]int)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		lines := strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 10 {
+				continue
+			}
+			// Field 3 is connection state in hex; 0A is TCP_LISTEN.
+			if fields[3] != "0A" {
+				continue
+			}
+			addrPort := strings.Split(fields[1], ":")
+			if len(addrPort) != 2 {
+				continue
+			}
+			port, err := strconv.ParseInt(addrPort[1], 16, 32)
+			if err != nil {
+				continue
+			}
+			inodeToPort[fields[9]] = int(port)
+		}
+	}
+	return inodeToPort, nil
+}
@@ -0,0 +1,50 @@
+// +build linux
+
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanProcNetListensOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portindex-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tcp := filepath.Join(dir, "tcp")
+	// Local address :1F90 is port 8080; state 0A is TCP_LISTEN, 01 is
+	// TCP_ESTABLISHED.
+	contents := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+		"   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 111 1 0000000000000000 100 0 0 10 0\n" +
+		"   1: 0100007F:0050 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 222 1 0000000000000000 100 0 0 10 0\n"
+	if err := ioutil.WriteFile(tcp, []byte(contents), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inodeToPort, err := scanProcNet([]string{tcp})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if port, ok := inodeToPort["111"]; !ok || port != 8080 {
+		t.Errorf("expected inode 111 to map to port 8080, got %v (ok=%v)", port, ok)
+	}
+	if _, ok := inodeToPort["222"]; ok {
+		t.Error("expected the ESTABLISHED connection to be excluded")
+	}
+}
+
+func TestScanProcNetMissingFileIsIgnored(t *testing.T) {
+	inodeToPort, err := scanProcNet([]string{"/does/not/exist"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inodeToPort) != 0 {
+		t.Errorf("expected an empty map, got %v", inodeToPort)
+	}
+}
@@ -0,0 +1,27 @@
+// +build !linux
+
+package services
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/net"
+)
+
+// listeningPorts returns every LISTEN-state port, keyed by owning pid, using
+// gopsutil's cross-platform connection enumeration. Linux has a faster,
+// syscall-free implementation in portindex_linux.go.
+func listeningPorts() (map[int32][]int, error) {
+	connections, err := net.Connections("all")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	byPid := make(map[int32][]int)
+	for _, connection := range connections {
+		if connection.Status != "LISTEN" {
+			continue
+		}
+		byPid[connection.Pid] = append(byPid[connection.Pid], int(connection.Laddr.Port))
+	}
+	return byPid, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// logLineWriter turns a raw byte stream (such as a PTY master fd) into the
+// same newline-delimited JSON format used by the ordinary pipe-backed run
+// log, so getLogCounts and other log readers don't need to know a service
+// was launched under a PTY.
+type logLineWriter struct {
+	file   *os.File
+	stream string
+	buf    []byte
+}
+
+func newLogLineWriter(path, stream string) (*logLineWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &logLineWriter{file: file, stream: stream}, nil
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := indexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.writeLine(string(w.buf[:idx]))
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *logLineWriter) writeLine(text string) {
+	event := struct {
+		Stream string `json:"stream"`
+		Time   int64  `json:"time"`
+		Text   string `json:"text"`
+	}{Stream: w.stream, Time: time.Now().Unix(), Text: text}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	w.file.Write(data)
+}
+
+func (w *logLineWriter) Close() error {
+	return w.file.Close()
+}
+
+func indexByte(b []byte, c byte) int {
+	for i, v := range b {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// launchTty runs this service's launch command attached to a pseudo-terminal
+// (see startWithPTY) instead of plain pipes, for programs that behave
+// differently when their stdout isn't a TTY. The session is torn down by
+// stopTty, called from Stop.
+func (c *ServiceConfig) launchTty(command *ServiceCommand) error {
+	cmd := exec.Command("sh", "-c", c.Commands.Launch)
+	if c.Path != nil {
+		cmd.Dir = *c.Path
+	}
+	cmd.Env = append(os.Environ(), c.Env...)
+
+	logWriter, err := newLogLineWriter(c.GetRunLog(), "stdout")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	session, err := startWithPTY(cmd, logWriter)
+	if err != nil {
+		logWriter.Close()
+		return errors.WithStack(err)
+	}
+
+	command.Pid = cmd.Process.Pid
+	if err := ioutil.WriteFile(command.getPidPath(), []byte(strconv.Itoa(command.Pid)), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.ptySession = session
+	return nil
+}
+
+// stopTty closes the PTY session for a Tty-launched service, if one is open
+// in this process.
+func (c *ServiceConfig) stopTty() {
+	if c.ptySession == nil {
+		return
+	}
+	if err := stopPTY(c.ptySession); err != nil {
+		c.printf("could not close pty for %v: %v\n", c.Name, err)
+	}
+	c.ptySession = nil
+}
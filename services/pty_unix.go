@@ -0,0 +1,73 @@
+// +build !windows
+
+package services
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+)
+
+// ptySupported is true on platforms where startWithPTY can actually open a
+// pseudo-terminal; ServiceConfig.Launch falls back to the plain pipe-backed
+// path when it's false, regardless of Commands.Tty.
+const ptySupported = true
+
+// ptySession bundles the resources startWithPTY creates, so stopPTY can tear
+// all of them down together, including the SIGWINCH watcher goroutine.
+type ptySession struct {
+	master *os.File
+	stop   func() error
+}
+
+// startWithPTY runs cmd attached to a pseudo-terminal instead of plain pipes.
+// The master fd is copied into logWriter, which the caller is expected to
+// have tagged as the "stdout" stream so the run log keeps its usual JSON
+// format. The pty is kept sized to Edward's own terminal on SIGWINCH until
+// the session is closed via stopPTY.
+func startWithPTY(cmd *exec.Cmd, logWriter io.Writer) (*ptySession, error) {
+	master, err := pty.Start(cmd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pty.InheritSize(os.Stdin, master)
+
+	winch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(winch)
+		for {
+			select {
+			case <-winch:
+				pty.InheritSize(os.Stdin, master)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go io.Copy(logWriter, master)
+
+	return &ptySession{
+		master: master,
+		stop: func() error {
+			close(done)
+			return master.Close()
+		},
+	}, nil
+}
+
+// stopPTY closes a PTY session opened by startWithPTY, including its
+// SIGWINCH watcher goroutine.
+func stopPTY(session *ptySession) error {
+	if session == nil {
+		return nil
+	}
+	return errors.WithStack(session.stop())
+}
@@ -0,0 +1,28 @@
+// +build windows
+
+package services
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// ptySupported is false on Windows; ServiceConfigCommands.Tty is ignored
+// there and Launch always falls back to the plain pipe-backed path.
+const ptySupported = false
+
+// ptySession is an empty placeholder on Windows, since startWithPTY never
+// succeeds there.
+type ptySession struct{}
+
+// startWithPTY is unavailable on Windows.
+func startWithPTY(cmd *exec.Cmd, logWriter io.Writer) (*ptySession, error) {
+	return nil, errors.New("pty-backed launch is not supported on Windows")
+}
+
+// stopPTY is a no-op on Windows since startWithPTY never succeeds there.
+func stopPTY(session *ptySession) error {
+	return nil
+}
@@ -13,7 +13,6 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/net"
 	"github.com/shirou/gopsutil/process"
 	"github.com/yext/edward/common"
 	"github.com/yext/edward/home"
@@ -49,6 +48,30 @@ type ServiceConfig struct {
 
 	// Action for warming up this service
 	Warmup *warmup.Warmup `json:"warmup,omitempty"`
+
+	// AutoRestart enables supervisor-style monitoring of the launched
+	// process: if set, Edward will watch for the process exiting and
+	// relaunch it, following the StartSeconds/StartRetries/RestartBackoff
+	// settings below.
+	AutoRestart bool `json:"auto_restart,omitempty"`
+	// StartSeconds is how long the process must stay running to be
+	// considered successfully started, which resets the retry count.
+	// Exiting before this counts against StartRetries.
+	StartSeconds int `json:"start_seconds,omitempty"`
+	// StartRetries is the number of times a service that exits within
+	// StartSeconds will be restarted before being marked StatusFatal.
+	StartRetries int `json:"start_retries,omitempty"`
+	// RestartBackoff is the base delay before a restart attempt. It doubles
+	// with each consecutive failed attempt, up to a fixed cap.
+	RestartBackoff Duration `json:"restart_backoff,omitempty"`
+
+	// HealthCheck, if set, is probed for the life of the service to track
+	// HealthState, independently of LaunchChecks.
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// ptySession, when non-nil, is the PTY opened by launchTty, torn down by
+	// stopTty.
+	ptySession *ptySession
 }
 
 // UnmarshalJSON provides additional handling when unmarshaling a service from config.
@@ -77,12 +100,39 @@ func (c *ServiceConfig) UnmarshalJSON(data []byte) error {
 	return errors.WithStack(c.validate())
 }
 
+// defaultStartSeconds is used when AutoRestart is set but StartSeconds isn't,
+// matching supervisord's startsecs default of 1.
+const defaultStartSeconds = 1
+
 // validate checks if this config is allowed
 func (c *ServiceConfig) validate() error {
 	if c.LaunchChecks != nil {
-		if len(c.LaunchChecks.LogText) > 0 && len(c.LaunchChecks.Ports) > 0 {
+		hasLogCheck := len(c.LaunchChecks.LogText) > 0 || len(c.LaunchChecks.LogTextRegex) > 0
+		if hasLogCheck && len(c.LaunchChecks.Ports) > 0 {
 			return errors.New("cannot specify both a log and port launch check")
 		}
+		if len(c.LaunchChecks.LogText) > 0 && len(c.LaunchChecks.LogTextRegex) > 0 {
+			return errors.New("cannot specify both log_text and log_text_regex")
+		}
+	}
+	if c.AutoRestart && c.StartSeconds <= 0 {
+		// Matches supervisord's startsecs default of 1: without this, a
+		// config that only sets auto_restart defaults StartSeconds to 0,
+		// and a process that crashes instantly would always be considered
+		// to have "lived long enough", restarting forever with no backoff
+		// and no path to StatusFatal.
+		c.StartSeconds = defaultStartSeconds
+	}
+	if c.HealthCheck != nil {
+		set := 0
+		for _, probe := range []bool{c.HealthCheck.HTTPGet != nil, c.HealthCheck.TCP != nil, c.HealthCheck.Exec != nil} {
+			if probe {
+				set++
+			}
+		}
+		if set != 1 {
+			return errors.New("health_check must set exactly one of http_get, tcp or exec")
+		}
 	}
 	return nil
 }
@@ -152,8 +202,17 @@ func (c *ServiceConfig) printf(format string, v ...interface{}) {
 type LaunchChecks struct {
 	// A string to look for in the service's logs that indicates it has completed startup
 	LogText string `json:"log_text,omitempty"`
+	// A regex to look for in the service's logs, as an alternative to LogText
+	LogTextRegex string `json:"log_text_regex,omitempty"`
 	// One or more specific ports that are expected to be opened when this service starts
 	Ports []int `json:"ports,omitempty"`
+	// Timeout bounds how long Launch will wait for LogText/LogTextRegex to
+	// appear before failing. Defaults to 30s.
+	Timeout Duration `json:"timeout,omitempty"`
+	// StartPeriod tolerates "address already in use" lines while a prior
+	// instance of the service is still shutting down, instead of failing
+	// the launch check immediately.
+	StartPeriod Duration `json:"start_period,omitempty"`
 }
 
 // ServiceConfigProperties provides a set of regexes to detect properties of a service
@@ -174,6 +233,12 @@ type ServiceConfigCommands struct {
 	Launch string `json:"launch,omitempty"`
 	// Optional command to stop
 	Stop string `json:"stop,omitempty"`
+	// Tty runs the launch command attached to a pseudo-terminal instead of
+	// plain pipes. Some programs (REPL-ish dev servers, Rails, anything that
+	// checks isatty to decide how to buffer or format output) behave badly
+	// under a pipe; this fixes both unreadable logs and LaunchChecks.LogText
+	// sentinels that never flush. Ignored on Windows.
+	Tty bool `json:"pty,omitempty"`
 }
 
 // GetName returns the name for this service
@@ -204,7 +269,76 @@ func (c *ServiceConfig) Launch(cfg OperationConfig) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return errors.WithStack(command.StartAsync(cfg))
+
+	if c.AutoRestart {
+		state, err := c.loadSupervisorState()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if state.FatalReason != "" {
+			return errors.Errorf("%v is marked fatal (%v); call ResetFatal before launching again", c.Name, state.FatalReason)
+		}
+	}
+
+	// Captured before the process starts, so WaitForLogText tails from
+	// this run's own output instead of from wherever the log happens to be
+	// once its tailer goroutine gets scheduled - otherwise a sentinel
+	// written in that gap would be silently skipped.
+	preLaunchLogSize := c.LogSize()
+
+	if c.Commands.Tty && ptySupported {
+		if err := c.launchTty(command); err != nil {
+			return errors.WithStack(err)
+		}
+	} else if err := command.StartAsync(cfg); err != nil {
+		return errors.WithStack(err)
+	}
+	invalidatePortIndex()
+
+	if c.LaunchChecks != nil && (c.LaunchChecks.LogText != "" || c.LaunchChecks.LogTextRegex != "") {
+		tracker := CommandTracker{
+			Name:       "Waiting for " + c.Name,
+			Logger:     c.Logger,
+			OutputFile: "",
+		}
+		tracker.Start()
+		err := c.WaitForLogText(preLaunchLogSize, func(linesScanned int, elapsed time.Duration) {
+			if linesScanned%20 == 0 {
+				c.printf("%v: still waiting for %q after %v (%d lines scanned)\n", c.Name, c.LaunchChecks.sentinelDescription(), elapsed.Round(time.Second), linesScanned)
+			}
+		})
+		if err != nil {
+			tracker.Fail(err)
+			return errors.WithStack(err)
+		}
+		tracker.Success()
+	}
+
+	if c.AutoRestart {
+		if err := c.StartSupervisor(command.Pid); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if c.HealthCheck != nil {
+		if err := c.StartHealthCheck(command.Pid); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// cancelBackgroundWatchers tears down the detached supervisor and health
+// check processes, if running, without touching the service's process.
+func (c *ServiceConfig) cancelBackgroundWatchers() {
+	if err := c.StopSupervisor(); err != nil {
+		c.printf("could not stop supervisor for %v: %v\n", c.Name, err)
+	}
+	if err := c.StopHealthCheck(); err != nil {
+		c.printf("could not stop health check for %v: %v\n", c.Name, err)
+	}
+	c.stopTty()
 }
 
 // Start builds then launches this service
@@ -234,6 +368,8 @@ func (c *ServiceConfig) Stop(cfg OperationConfig) error {
 	}
 	tracker.Start()
 
+	c.cancelBackgroundWatchers()
+
 	command, err := c.GetCommand()
 	if err != nil {
 		return errors.WithStack(err)
@@ -274,6 +410,9 @@ func (c *ServiceConfig) Stop(cfg OperationConfig) error {
 
 	// Remove leftover files
 	command.clearState()
+	c.clearSupervisorState()
+	c.clearHealthState()
+	invalidatePortIndex()
 	tracker.Success()
 	return nil
 }
@@ -336,6 +475,10 @@ func (c *ServiceConfig) Status() ([]ServiceStatus, error) {
 		Status:  StatusStopped,
 	}
 
+	if supervisorState, err := c.loadSupervisorState(); err == nil && supervisorState.FatalReason != "" {
+		status.Status = StatusFatal
+	}
+
 	if command.Pid != 0 {
 		status.Status = StatusRunning
 		status.Pid = command.Pid
@@ -355,14 +498,16 @@ func (c *ServiceConfig) Status() ([]ServiceStatus, error) {
 		}
 	}
 
+	if health, checkedAt := c.Health(); health != "" {
+		status.Health = string(health)
+		status.HealthCheckedAt = checkedAt
+	}
+
 	return []ServiceStatus{
 		status,
 	}, nil
 }
 
-// Connection list cache, created once per session.
-var connectionsCache []net.ConnectionStat
-
 func (c *ServiceConfig) getPorts(proc *process.Process) ([]string, error) {
 	ports, err := c.doGetPorts(proc)
 	if err != nil {
@@ -402,39 +547,26 @@ func (c *ServiceConfig) getLogCounts() (int, int) {
 	return stdoutCount, stderrCount
 }
 
+// doGetPorts looks up proc (and, via PortIndex, all of its descendants) in
+// the shared per-Status() port snapshot, rather than walking connections and
+// proc.Children() itself. See PortIndex for the single-pass build.
 func (c *ServiceConfig) doGetPorts(proc *process.Process) ([]string, error) {
-	var err error
-	if len(connectionsCache) == 0 {
-		connectionsCache, err = net.Connections("all")
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
+	idx, err := getPortIndex()
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	var ports []string
-	var knownPorts = make(map[int]struct{})
+	knownPorts := make(map[int]struct{})
 	if c.LaunchChecks != nil {
 		for _, port := range c.LaunchChecks.Ports {
 			knownPorts[port] = struct{}{}
 		}
 	}
-	for _, connection := range connectionsCache {
-		if connection.Status == "LISTEN" {
-			if _, ok := knownPorts[int(connection.Laddr.Port)]; connection.Pid == proc.Pid && !ok {
-				ports = append(ports, strconv.Itoa(int(connection.Laddr.Port)))
-			}
-		}
-	}
 
-	children, err := proc.Children()
-	// This will error out if the process has finished or has no children
-	if err != nil {
-		return ports, nil
-	}
-	for _, child := range children {
-		childPorts, err := c.doGetPorts(child)
-		if err == nil {
-			ports = append(ports, childPorts...)
+	var ports []string
+	for _, port := range idx.Ports(proc.Pid) {
+		if _, ok := knownPorts[port]; !ok {
+			ports = append(ports, strconv.Itoa(port))
 		}
 	}
 	return ports, nil
@@ -486,6 +618,7 @@ func (c *ServiceConfig) GetCommand() (*ServiceCommand, error) {
 		if !exists {
 			c.printf("Process for %v was not found, resetting.\n", c.Name)
 			command.clearState()
+			c.cancelBackgroundWatchers()
 		}
 
 		proc, err := process.NewProcess(int32(command.Pid))
@@ -499,6 +632,7 @@ func (c *ServiceConfig) GetCommand() (*ServiceCommand, error) {
 		if !strings.Contains(cmdline, c.Name) {
 			c.printf("Process for %v was not as expected (found %v), resetting.\n", c.Name, cmdline)
 			command.clearState()
+			c.cancelBackgroundWatchers()
 		}
 
 	} else {
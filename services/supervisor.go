@@ -0,0 +1,367 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+	"github.com/yext/edward/home"
+)
+
+// StatusFatal indicates that a supervised service failed to stay up for
+// StartSeconds enough times to exhaust StartRetries, and the supervisor has
+// given up restarting it. Alongside StatusRunning/StatusStopped.
+const StatusFatal = "Fatal"
+
+// restartBackoffCap bounds the exponential backoff between restart attempts.
+const restartBackoffCap = 30 * time.Second
+
+// internalSuperviseFlag is the hidden argument edward's main() must check
+// for, before normal command-line parsing: if os.Args[1] equals this, the
+// process is a detached supervisor re-exec started by StartSupervisor, and
+// main() should call RunInternalSupervisor(os.Args[2]) and exit rather than
+// running the usual CLI.
+//
+// This indirection exists because `edward start` launches a service as a
+// detached process and then exits; an in-process goroutine would die with
+// it and could never be reached by a later, separate `edward stop`. The
+// supervisor instead runs as its own long-lived process, re-exec'ing the
+// edward binary, so it outlives the command that started it.
+const internalSuperviseFlag = "--edward-internal-supervise"
+
+// supervisorSpec is everything a detached supervisor process needs to watch
+// and relaunch a service, since it has no access to the edward.json or
+// in-memory ServiceConfig that started it.
+type supervisorSpec struct {
+	Name           string   `json:"name"`
+	Launch         string   `json:"launch"`
+	Path           string   `json:"path,omitempty"`
+	Env            []string `json:"env,omitempty"`
+	StartSeconds   int      `json:"startSeconds"`
+	StartRetries   int      `json:"startRetries"`
+	RestartBackoff Duration `json:"restartBackoff"`
+	PidFile        string   `json:"pidFile"`
+	LogFile        string   `json:"logFile"`
+	InitialPid     int      `json:"initialPid"`
+}
+
+// SupervisorState is the persisted record of a service's auto-restart
+// history. It is stored next to the pid file so that restart counts and
+// fatal reasons are visible to any later edward invocation, not just the one
+// that launched the service.
+type SupervisorState struct {
+	// RetryLeft is the number of restart attempts remaining before the
+	// service is marked StatusFatal.
+	RetryLeft int `json:"retryLeft"`
+	// FatalReason is set once the service has been marked StatusFatal,
+	// explaining why the supervisor gave up.
+	FatalReason string `json:"fatalReason,omitempty"`
+}
+
+// GetSupervisorStatePath returns the path to the persisted supervisor state
+// for this service.
+func (c *ServiceConfig) GetSupervisorStatePath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".supervisor")
+}
+
+// GetSupervisorSpecPath returns the path to the persisted supervisorSpec
+// that a detached supervisor process reads on startup.
+func (c *ServiceConfig) GetSupervisorSpecPath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".supervisor.json")
+}
+
+// GetSupervisorPidPath returns the path to the pid file for this service's
+// detached supervisor process, if one is running.
+func (c *ServiceConfig) GetSupervisorPidPath() string {
+	return path.Join(home.EdwardConfig.LogDir, c.Name+".supervisor.pid")
+}
+
+// SupervisorState returns the persisted restart history for this service, so
+// that callers such as `edward status` can report on it.
+func (c *ServiceConfig) SupervisorState() (SupervisorState, error) {
+	return c.loadSupervisorState()
+}
+
+// ResetFatal clears a service's fatal supervisor state, allowing it to be
+// launched again without editing state files by hand.
+func (c *ServiceConfig) ResetFatal() error {
+	return c.saveSupervisorState(SupervisorState{})
+}
+
+func (c *ServiceConfig) loadSupervisorState() (SupervisorState, error) {
+	var state SupervisorState
+	data, err := ioutil.ReadFile(c.GetSupervisorStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, errors.WithStack(err)
+	}
+	return state, nil
+}
+
+func (c *ServiceConfig) saveSupervisorState(state SupervisorState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(c.GetSupervisorStatePath(), data, 0644))
+}
+
+func (c *ServiceConfig) clearSupervisorState() {
+	os.Remove(c.GetSupervisorStatePath())
+	os.Remove(c.GetSupervisorSpecPath())
+}
+
+// supervisedPidFile is where a detached supervisor records the pid of the
+// service process it is currently watching, following the same
+// dir/Name+extension convention as GetRunLog.
+func (c *ServiceConfig) supervisedPidFile() string {
+	return path.Join(home.EdwardConfig.PidDir, c.Name+".pid")
+}
+
+// StartSupervisor launches a detached process that watches pid and restarts
+// the service according to AutoRestart/StartSeconds/StartRetries/
+// RestartBackoff, following supervisord-style semantics (see
+// RunInternalSupervisor). The supervisor re-execs the edward binary with
+// internalSuperviseFlag and is re-parented to init (via Setsid), so it keeps
+// running after the `edward start` invocation that called this returns, and
+// can be found and stopped later by StopSupervisor from a separate `edward
+// stop` process.
+func (c *ServiceConfig) StartSupervisor(pid int) error {
+	if c.StartSeconds <= 0 {
+		return errors.Errorf("%v has auto_restart enabled with a non-positive start_seconds (%d)", c.Name, c.StartSeconds)
+	}
+
+	var servicePath string
+	if c.Path != nil {
+		servicePath = *c.Path
+	}
+
+	spec := supervisorSpec{
+		Name:           c.Name,
+		Launch:         c.Commands.Launch,
+		Path:           servicePath,
+		Env:            c.Env,
+		StartSeconds:   c.StartSeconds,
+		StartRetries:   c.StartRetries,
+		RestartBackoff: c.RestartBackoff,
+		PidFile:        c.supervisedPidFile(),
+		LogFile:        c.GetRunLog(),
+		InitialPid:     pid,
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(c.GetSupervisorSpecPath(), data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer devnull.Close()
+
+	cmd := exec.Command(self, internalSuperviseFlag, c.GetSupervisorSpecPath())
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Stdin = devnull
+	cmd.Stdout = devnull
+	cmd.Stderr = devnull
+	if err := cmd.Start(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(c.GetSupervisorPidPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0644))
+}
+
+// StopSupervisor signals a detached supervisor started by StartSupervisor to
+// exit without restarting the service. Safe to call even if no supervisor is
+// running.
+func (c *ServiceConfig) StopSupervisor() error {
+	data, err := ioutil.ReadFile(c.GetSupervisorPidPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	pid, err := strconv.Atoi(string(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if p, err := process.NewProcess(int32(pid)); err == nil {
+		p.SendSignal(syscall.SIGTERM)
+	}
+	os.Remove(c.GetSupervisorPidPath())
+	return nil
+}
+
+// RunInternalSupervisor is the entry point for a detached supervisor process
+// started by StartSupervisor. It implements supervisord-style semantics: a
+// process that stays up for at least StartSeconds is considered to have
+// started successfully, which resets the retry counter and triggers an
+// immediate restart; one that exits sooner consumes a restart attempt and
+// backs off exponentially (capped at restartBackoffCap) before relaunching.
+// Once StartRetries is exhausted, the service is marked StatusFatal and this
+// function returns. It also returns if sent SIGTERM/SIGINT, which
+// StopSupervisor uses to cancel an intentional stop.
+func RunInternalSupervisor(specPath string) error {
+	data, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var spec supervisorSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c := &ServiceConfig{Name: spec.Name}
+	retryLeft := spec.StartRetries
+	if state, err := c.loadSupervisorState(); err == nil && state.RetryLeft > 0 {
+		retryLeft = state.RetryLeft
+	}
+
+	backoff := time.Duration(spec.RestartBackoff)
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	cancel := installSignalCancel()
+	pid := spec.InitialPid
+	for {
+		startedAt := time.Now()
+		if !waitForExit(pid, cancel) {
+			return nil
+		}
+
+		decision := nextSupervisorAction(time.Since(startedAt), spec.StartSeconds, spec.StartRetries, retryLeft, backoff)
+		retryLeft = decision.retryLeft
+		if decision.fatal {
+			reason := errors.Errorf("%v exited within %ds of starting, %d times in a row", spec.Name, spec.StartSeconds, spec.StartRetries+1).Error()
+			c.saveSupervisorState(SupervisorState{FatalReason: reason})
+			return nil
+		}
+		c.saveSupervisorState(SupervisorState{RetryLeft: retryLeft})
+		if decision.wait > 0 {
+			select {
+			case <-time.After(decision.wait):
+			case <-cancel:
+				return nil
+			}
+		}
+
+		newPid, err := relaunch(spec)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		pid = newPid
+	}
+}
+
+// supervisorAction is what RunInternalSupervisor should do after a
+// supervised process exits.
+type supervisorAction struct {
+	// retryLeft is the new restart-attempt count to persist.
+	retryLeft int
+	// fatal means StartRetries is exhausted and the service should be
+	// marked StatusFatal instead of relaunched.
+	fatal bool
+	// wait is how long to back off before relaunching; zero means relaunch
+	// immediately.
+	wait time.Duration
+}
+
+// nextSupervisorAction decides what RunInternalSupervisor should do after a
+// supervised process that was running for ranFor exits, separated out from
+// the file/signal IO in RunInternalSupervisor so the state machine (lives
+// long enough resets retryLeft, otherwise consumes a retry and backs off
+// exponentially up to restartBackoffCap, going fatal once retryLeft is
+// exhausted) can be tested directly.
+func nextSupervisorAction(ranFor time.Duration, startSeconds, startRetries, retryLeft int, backoff time.Duration) supervisorAction {
+	if ranFor >= time.Duration(startSeconds)*time.Second {
+		return supervisorAction{retryLeft: startRetries}
+	}
+	if retryLeft <= 0 {
+		return supervisorAction{fatal: true}
+	}
+	retryLeft--
+	wait := backoff << uint(startRetries-retryLeft-1)
+	if wait > restartBackoffCap || wait <= 0 {
+		wait = restartBackoffCap
+	}
+	return supervisorAction{retryLeft: retryLeft, wait: wait}
+}
+
+// relaunch starts a fresh instance of the service described by spec,
+// records its pid to spec.PidFile (the same file the original ServiceCommand
+// reads its pid from) and returns its pid for the supervisor to watch next.
+func relaunch(spec supervisorSpec) (int, error) {
+	cmd := exec.Command("sh", "-c", spec.Launch)
+	cmd.Dir = spec.Path
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	logFile, err := os.OpenFile(spec.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if spec.PidFile != "" {
+		ioutil.WriteFile(spec.PidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// installSignalCancel returns a channel that closes the first time this
+// process receives SIGTERM or SIGINT, used by RunInternalSupervisor to tell
+// an intentional stop apart from the service process simply exiting.
+func installSignalCancel() <-chan struct{} {
+	cancel := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		close(cancel)
+	}()
+	return cancel
+}
+
+// waitForExit blocks until the process identified by pid is no longer
+// running, or cancel is closed. It returns false if cancelled.
+func waitForExit(pid int, cancel <-chan struct{}) bool {
+	for {
+		select {
+		case <-cancel:
+			return false
+		case <-time.After(250 * time.Millisecond):
+		}
+		exists, err := process.PidExists(int32(pid))
+		if err != nil || !exists {
+			return true
+		}
+	}
+}
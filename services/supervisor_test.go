@@ -0,0 +1,102 @@
+package services
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextSupervisorActionRanLongEnoughResetsRetryLeft(t *testing.T) {
+	action := nextSupervisorAction(5*time.Second, 2, 3, 0, time.Second)
+	if action.fatal {
+		t.Error("expected a service that ran long enough not to be marked fatal")
+	}
+	if action.retryLeft != 3 {
+		t.Errorf("got retryLeft %d, want 3 (reset to StartRetries)", action.retryLeft)
+	}
+	if action.wait != 0 {
+		t.Errorf("expected an immediate relaunch, got a %v wait", action.wait)
+	}
+}
+
+func TestNextSupervisorActionBackoffGrowsThenCaps(t *testing.T) {
+	backoff := time.Second
+	startRetries := 6
+	retryLeft := startRetries
+
+	var waits []time.Duration
+	for i := 0; i < startRetries; i++ {
+		action := nextSupervisorAction(0, 10, startRetries, retryLeft, backoff)
+		if action.fatal {
+			t.Fatalf("unexpected fatal transition at attempt %d", i)
+		}
+		waits = append(waits, action.wait)
+		retryLeft = action.retryLeft
+	}
+
+	want := []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		restartBackoffCap, // 32s would exceed the cap
+	}
+	for i, w := range want {
+		if waits[i] != w {
+			t.Errorf("attempt %d: got wait %v, want %v", i, waits[i], w)
+		}
+	}
+}
+
+func TestNextSupervisorActionFatalOnceRetriesExhausted(t *testing.T) {
+	action := nextSupervisorAction(0, 10, 3, 0, time.Second)
+	if !action.fatal {
+		t.Error("expected a service with no retries left to be marked fatal")
+	}
+}
+
+func TestInstallSignalCancelClosesOnSigterm(t *testing.T) {
+	cancel := installSignalCancel()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error sending SIGTERM to self: %v", err)
+	}
+
+	select {
+	case <-cancel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for installSignalCancel to observe SIGTERM")
+	}
+}
+
+func TestWaitForExitReturnsTrueWhenProcessExits(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 0.2")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cmd.Wait()
+
+	done := make(chan struct{})
+	if !waitForExit(cmd.Process.Pid, done) {
+		t.Error("expected waitForExit to return true once the process exited")
+	}
+}
+
+func TestWaitForExitReturnsFalseWhenCancelled(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 5")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if waitForExit(cmd.Process.Pid, cancel) {
+		t.Error("expected waitForExit to return false once cancelled")
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SystemdOptions controls how GenerateSystemdUnit renders a unit file.
+type SystemdOptions struct {
+	// User generates a unit intended for `systemctl --user`, which never
+	// sets User=root regardless of RequiresSudo.
+	User bool
+	// After lists additional units this one should start after, beyond the
+	// default network-online.target.
+	After []string
+	// Description overrides the generated [Unit] Description. Defaults to
+	// the service name.
+	Description string
+}
+
+// GenerateSystemdUnit renders a systemd unit file for this service, derived
+// from Commands, Env, Path, RequiresSudo and LaunchChecks, so that a
+// long-running Edward-managed service can be handed off to systemd on a
+// production box while the edward.json remains the source of truth.
+func (c *ServiceConfig) GenerateSystemdUnit(opts SystemdOptions) ([]byte, error) {
+	if c.Commands.Launch == "" {
+		return nil, errors.Errorf("service %v has no launch command to generate a unit for", c.Name)
+	}
+
+	var buf bytes.Buffer
+
+	description := opts.Description
+	if description == "" {
+		description = c.Name
+	}
+
+	fmt.Fprintf(&buf, "[Unit]\n")
+	fmt.Fprintf(&buf, "Description=%s (managed by Edward)\n", description)
+	after := append([]string{"network-online.target"}, opts.After...)
+	fmt.Fprintf(&buf, "After=%s\n", strings.Join(after, " "))
+	fmt.Fprintf(&buf, "\n[Service]\n")
+
+	// A LogText launch check has no systemd-native equivalent, so we keep
+	// Type=simple and approximate "wait for the sentinel" with an
+	// ExecStartPost that polls the run log.
+	fmt.Fprintf(&buf, "Type=simple\n")
+
+	if c.Path != nil {
+		fmt.Fprintf(&buf, "WorkingDirectory=%s\n", *c.Path)
+	}
+
+	if !opts.User && c.RequiresSudo {
+		fmt.Fprintf(&buf, "User=root\n")
+	}
+
+	for _, env := range c.Env {
+		fmt.Fprintf(&buf, "Environment=%s\n", env)
+	}
+
+	fmt.Fprintf(&buf, "ExecStart=/bin/sh -c %s\n", shellQuote(c.Commands.Launch))
+	if c.Commands.Stop != "" {
+		fmt.Fprintf(&buf, "ExecStop=/bin/sh -c %s\n", shellQuote(c.Commands.Stop))
+	}
+
+	if c.LaunchChecks != nil && (c.LaunchChecks.LogText != "" || c.LaunchChecks.LogTextRegex != "") {
+		// grep -F for LogText matches the literal-substring semantics
+		// WaitForLogText uses (strings.Contains); grep -E for LogTextRegex
+		// matches its regexp semantics. The overall wait is the same
+		// Timeout (and default) that WaitForLogText uses.
+		grepFlag := "-F"
+		pattern := c.LaunchChecks.LogText
+		if c.LaunchChecks.LogTextRegex != "" {
+			grepFlag = "-E"
+			pattern = c.LaunchChecks.LogTextRegex
+		}
+
+		timeout := time.Duration(c.LaunchChecks.Timeout)
+		if timeout <= 0 {
+			timeout = defaultLogTextTimeout
+		}
+
+		fmt.Fprintf(&buf, "ExecStartPost=/bin/sh -c %s\n", shellQuote(fmt.Sprintf(
+			"timeout %d tail -n0 -F %s | grep -qm1 %s %s",
+			int(timeout.Seconds()), c.GetRunLog(), grepFlag, shellQuote(pattern),
+		)))
+	}
+
+	if c.AutoRestart {
+		fmt.Fprintf(&buf, "Restart=on-failure\n")
+		backoff := time.Duration(c.RestartBackoff)
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		fmt.Fprintf(&buf, "RestartSec=%d\n", int(backoff.Seconds()))
+		if c.StartRetries > 0 {
+			fmt.Fprintf(&buf, "StartLimitBurst=%d\n", c.StartRetries)
+		}
+	}
+
+	fmt.Fprintf(&buf, "\n[Install]\n")
+	if opts.User {
+		fmt.Fprintf(&buf, "WantedBy=default.target\n")
+	} else {
+		fmt.Fprintf(&buf, "WantedBy=multi-user.target\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GenerateSystemdTarget renders a `.target` unit that groups a set of
+// services, plus one `.service` unit per service, so a whole Edward group
+// can be installed on systemd in one pass.
+func GenerateSystemdTarget(name string, group []*ServiceConfig, opts SystemdOptions) ([]byte, map[string][]byte, error) {
+	units := make(map[string][]byte, len(group))
+
+	var wants bytes.Buffer
+	for _, service := range group {
+		unit, err := service.GenerateSystemdUnit(opts)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		units[service.Name+".service"] = unit
+		fmt.Fprintf(&wants, " %s.service", service.Name)
+	}
+
+	var target bytes.Buffer
+	fmt.Fprintf(&target, "[Unit]\n")
+	fmt.Fprintf(&target, "Description=%s (Edward group)\n", name)
+	fmt.Fprintf(&target, "Wants=%s\n", strings.TrimSpace(wants.String()))
+
+	return target.Bytes(), units, nil
+}
+
+// shellQuote wraps s in single quotes for embedding in a systemd ExecStart=
+// line, escaping any single quotes already present.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateSystemdUnitBasics(t *testing.T) {
+	c := &ServiceConfig{
+		Name: "myservice",
+		Commands: ServiceConfigCommands{
+			Launch: "run.sh",
+			Stop:   "stop.sh",
+		},
+		Env:          []string{"FOO=bar"},
+		RequiresSudo: true,
+	}
+
+	unit, err := c.GenerateSystemdUnit(SystemdOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := string(unit)
+
+	for _, want := range []string{
+		"ExecStart=/bin/sh -c 'run.sh'",
+		"ExecStop=/bin/sh -c 'stop.sh'",
+		"Environment=FOO=bar",
+		"User=root",
+		"WantedBy=multi-user.target",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected unit to contain %q, got:\n%s", want, text)
+		}
+	}
+}
+
+func TestGenerateSystemdUnitUserIgnoresSudo(t *testing.T) {
+	c := &ServiceConfig{
+		Name:         "myservice",
+		Commands:     ServiceConfigCommands{Launch: "run.sh"},
+		RequiresSudo: true,
+	}
+
+	unit, err := c.GenerateSystemdUnit(SystemdOptions{User: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := string(unit)
+	if strings.Contains(text, "User=root") {
+		t.Errorf("--user units should never set User=root, got:\n%s", text)
+	}
+	if !strings.Contains(text, "WantedBy=default.target") {
+		t.Errorf("expected a --user unit to want default.target, got:\n%s", text)
+	}
+}
+
+func TestGenerateSystemdUnitLogTextUsesLiteralGrep(t *testing.T) {
+	c := &ServiceConfig{
+		Name:         "myservice",
+		Commands:     ServiceConfigCommands{Launch: "run.sh"},
+		LaunchChecks: &LaunchChecks{LogText: "started.", Timeout: Duration(10 * time.Second)},
+	}
+
+	unit, err := c.GenerateSystemdUnit(SystemdOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := string(unit)
+	if !strings.Contains(text, "grep -qm1 -F") {
+		t.Errorf("expected LogText to use grep -F (literal match), got:\n%s", text)
+	}
+	if !strings.Contains(text, "timeout 10 ") {
+		t.Errorf("expected the configured Timeout to be honored, got:\n%s", text)
+	}
+}
+
+func TestGenerateSystemdUnitLogTextRegexUsesExtendedGrep(t *testing.T) {
+	c := &ServiceConfig{
+		Name:         "myservice",
+		Commands:     ServiceConfigCommands{Launch: "run.sh"},
+		LaunchChecks: &LaunchChecks{LogTextRegex: "^started$"},
+	}
+
+	unit, err := c.GenerateSystemdUnit(SystemdOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := string(unit)
+	if !strings.Contains(text, "grep -qm1 -E") {
+		t.Errorf("expected LogTextRegex to use grep -E, got:\n%s", text)
+	}
+}
+
+func TestGenerateSystemdUnitNoLaunchCommand(t *testing.T) {
+	c := &ServiceConfig{Name: "myservice"}
+	if _, err := c.GenerateSystemdUnit(SystemdOptions{}); err == nil {
+		t.Error("expected an error when there is no launch command")
+	}
+}